@@ -13,7 +13,9 @@ import (
 	"go/format"
 	"go/token"
 	"go/types"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/tools/go/analysis"
@@ -45,6 +47,28 @@ Be aware that the most compact order is not always the most efficient.
 In rare cases it may cause two variables each updated by its own goroutine
 to occupy the same CPU cache line, inducing a form of memory contention
 known as "false sharing" that slows down both goroutines.
+
+By default the analyzer sizes structs for whatever architecture the analysis
+itself runs under. Pass -arch with a comma-separated list of GOARCH values
+(e.g. -arch=amd64,386,arm64) to instead check each struct against all of
+them; a diagnostic is only reported when the suggested order is a strict
+improvement on every listed architecture, and the message reports the
+per-architecture savings.
+
+Pass -falsesharing to opt into cache-line-aware reordering. Mark a field as
+"hot" -- written independently of its neighbors, typically by a different
+goroutine -- with a leading "//malignment:hotfield" comment or a
+` + "`malignment:\"hot\"`" + ` struct tag. In this mode the suggested fix gives each
+hot field its own 64-byte cache line, inserting synthetic "_ [N]byte" padding
+before and after it, and the analyzer separately flags any hot field whose
+*current* position already shares a cache line with another field.
+
+A struct can opt out of the analyzer entirely with a leading
+"//malignment:ignore" comment, and an individual field can be pinned to its
+current position with a ` + "`malignment:\"fixed\"`" + ` struct tag, e.g. because its
+offset is part of an ABI or wire format that can't change. When a fixed field
+forces a suboptimal layout, the finding is still reported but downgraded to
+an informational note with no suggested fix.
 `
 
 var Analyzer = &analysis.Analyzer{
@@ -54,14 +78,31 @@ var Analyzer = &analysis.Analyzer{
 	Run:  run,
 }
 
+var (
+	archFlag         string
+	falseSharingFlag bool
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&archFlag, "arch", "", "comma-separated GOARCH values to check field alignment against (e.g. \"amd64,386,arm64\"); defaults to the architecture the analysis runs under")
+	Analyzer.Flags.BoolVar(&falseSharingFlag, "falsesharing", false, "give fields marked hot (//malignment:hotfield or a `malignment:\"hot\"` tag) their own cache line, and flag existing false-sharing risk")
+}
+
 func run(pass *analysis.Pass) (interface{}, error) {
+	targets, err := archTargets(pass)
+	if err != nil {
+		return nil, err
+	}
 	for _, f := range pass.Files {
 		for _, decl := range f.Decls {
 			if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.TYPE { // 获取所有struct，保留所有信息
 				for _, spec := range genDecl.Specs {
 					if typeSpec, ok := spec.(*ast.TypeSpec); ok {
 						if s, ok := typeSpec.Type.(*ast.StructType); ok {
-							fieldalignment(typeSpec.Name.Name, pass, s)
+							if hasIgnoreDirective(genDecl, typeSpec) {
+								continue
+							}
+							fieldalignment(typeSpec.Name.Name, pass, f.Comments, targets, s)
 						}
 					}
 				}
@@ -71,105 +112,809 @@ func run(pass *analysis.Pass) (interface{}, error) {
 	return nil, nil
 }
 
+// ignoreDirective opts a struct out of the analyzer entirely, e.g. because
+// its layout is pinned by an external ABI or wire format.
+const ignoreDirective = "malignment:ignore"
+
+// hasIgnoreDirective reports whether typeSpec carries a leading
+// //malignment:ignore comment. For the common non-parenthesized
+// "type X struct{...}" form the parser attaches the doc comment to the
+// enclosing GenDecl instead of the TypeSpec, so that's checked too.
+func hasIgnoreDirective(genDecl *ast.GenDecl, typeSpec *ast.TypeSpec) bool {
+	return hasDirective(typeSpec.Doc, ignoreDirective) || hasDirective(genDecl.Doc, ignoreDirective)
+}
+
 var unsafePointerTyp = types.Unsafe.Scope().Lookup("Pointer").(*types.TypeName).Type()
 
-func resortFields(name string, pass *analysis.Pass, node ast.Expr) (ast.Expr, []string) {
+// archTarget is one architecture a struct is checked against: name is its
+// GOARCH (empty when sizing for whatever architecture the analysis runs
+// under, i.e. -arch wasn't given).
+type archTarget struct {
+	name  string
+	sizes gcSizes
+}
+
+// archTargets returns the architectures to check structs against: one
+// unnamed target derived from pass.TypesSizes by default, or one named
+// target per GOARCH listed in -arch.
+func archTargets(pass *analysis.Pass) ([]archTarget, error) {
+	if archFlag == "" {
+		wordSize := pass.TypesSizes.Sizeof(unsafePointerTyp)
+		maxAlign := pass.TypesSizes.Alignof(unsafePointerTyp)
+		return []archTarget{{sizes: gcSizes{wordSize, maxAlign, defaultCacheLine}}}, nil
+	}
+
+	var targets []archTarget
+	for _, arch := range strings.Split(archFlag, ",") {
+		arch = strings.TrimSpace(arch)
+		if arch == "" {
+			continue
+		}
+		std := types.SizesFor("gc", arch)
+		if std == nil {
+			return nil, fmt.Errorf("malignment: unknown GOARCH %q in -arch", arch)
+		}
+		targets = append(targets, archTarget{name: arch, sizes: gcSizes{std.Sizeof(unsafePointerTyp), std.Alignof(unsafePointerTyp), defaultCacheLine}})
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("malignment: -arch was given but named no architectures")
+	}
+	return targets, nil
+}
+
+// resortFields returns node (a struct or, recursively, one of its field
+// types) reordered into its optimal layout, the diagnostic messages earned
+// along the way, and whether the reorder is safe to offer as a SuggestedFix.
+// It's not fixable when a `malignment:"fixed"` field pinned part of the
+// struct away from its true optimal order: the improvement is still real and
+// worth reporting, but applying it automatically could be wrong, so the
+// caller should downgrade it to an informational note instead.
+func resortFields(name string, pass *analysis.Pass, comments []*ast.CommentGroup, targets []archTarget, node ast.Expr) (ast.Expr, []string, bool) {
 	// 优先处理子节点
 	var messages []string
 	var flat []*ast.Field
+	var lead, trail *ast.CommentGroup
+	fixable := true
 	switch typ := node.(type) {
 	case *ast.StructType:
+		lead, trail = floatingComments(comments, typ)
 		for _, f := range typ.Fields.List {
-			f.Comment = nil
-			f.Doc = nil
 			var tmp []string
+			var subFixable bool
 			var fname = "Anonymous"
 			if len(f.Names) > 0 {
 				fname = f.Names[0].Name
 			}
 
-			f.Type, tmp = resortFields(name+"."+fname, pass, f.Type)
+			f.Type, tmp, subFixable = resortFields(name+"."+fname, pass, comments, targets, f.Type)
 			messages = append(messages, tmp...)
+			fixable = fixable && subFixable
 
 			if len(f.Names) <= 1 {
 				flat = append(flat, f)
 				continue
 			}
-			for _, name := range f.Names {
-				flat = append(flat, &ast.Field{
-					Names: []*ast.Ident{name},
-					Type:  f.Type,
-				})
+			for i, fieldName := range f.Names {
+				split := &ast.Field{
+					Names: []*ast.Ident{fieldName},
+					// Each split field needs its own Type and Tag node:
+					// renumberPositions later walks every field independently,
+					// and a node shared by pointer across fields would have its
+					// position overwritten by whichever field is visited last.
+					Type: cloneTypeExpr(f.Type),
+					Tag:  cloneBasicLit(f.Tag),
+				}
+				if i == 0 {
+					split.Doc = f.Doc
+				}
+				if i == len(f.Names)-1 {
+					split.Comment = f.Comment
+				}
+				flat = append(flat, split)
 			}
 		}
 	case *ast.ArrayType:
 		var tmp []string
-		typ.Elt, tmp = resortFields(name, pass, typ.Elt)
+		var subFixable bool
+		typ.Elt, tmp, subFixable = resortFields(name, pass, comments, targets, typ.Elt)
 		messages = append(messages, tmp...)
-		return node, messages
+		return node, messages, subFixable
 	default:
-		return node, nil
+		return node, nil, true
 	}
 
 	tv, ok := pass.TypesInfo.Types[node] // struct 才有效
 	if !ok {
-		return node, nil
+		return node, nil, true
 	}
 
 	typ := tv.Type.(*types.Struct)
-	wordSize := pass.TypesSizes.Sizeof(unsafePointerTyp)
-	maxAlign := pass.TypesSizes.Alignof(unsafePointerTyp)
+	primary := targets[0].sizes
 
-	s := gcSizes{wordSize, maxAlign}
-	optimal, indexes := optimalOrder(typ, &s)
-	optsz, optptrs := s.Sizeof(optimal), s.ptrdata(optimal)
+	pinned := make([]bool, len(flat))
+	for i, f := range flat {
+		pinned[i] = isFixedField(f)
+	}
+	optimal, indexes := optimalOrder(typ, &primary, pinned)
+	// reportOptimal/reportIndexes are what the savings message (and, under
+	// -falsesharing, the padding layout) is measured against. They're the
+	// pinned optimal while it's still offerable as a fix; once a
+	// malignment:"fixed" field forces a layout that is larger, or has more
+	// pointer bytes, than the unconstrained optimal on some target, the
+	// improvement being given up lives in the unconstrained optimal instead,
+	// so fall back to that for the message and downgrade it to a note.
+	reportOptimal, reportIndexes := optimal, indexes
+	if anyPinned(pinned) {
+		unconstrained, unconstrainedIndexes := optimalOrder(typ, &primary, nil)
+		if !intsEqual(indexes, unconstrainedIndexes) {
+			for _, t := range targets {
+				psz, usz := t.sizes.Sizeof(optimal), t.sizes.Sizeof(unconstrained)
+				if psz > usz || (psz == usz && t.sizes.ptrdata(optimal) > t.sizes.ptrdata(unconstrained)) {
+					fixable = false
+					reportOptimal, reportIndexes = unconstrained, unconstrainedIndexes
+					break
+				}
+			}
+		}
+	}
 
-	if sz := s.Sizeof(typ); sz != optsz {
-		messages = append(messages, fmt.Sprintf("%s struct of size %d could be %d  save %.2f%%", name, sz, optsz, float64(sz-optsz)*100/float64(sz)))
-	} else if ptrs := s.ptrdata(typ); ptrs != optptrs {
-		messages = append(messages, fmt.Sprintf("%s struct with %d pointer bytes could be %d save %.2f%%", name, ptrs, optptrs, float64(ptrs-optptrs)*100/float64(sz)))
-	} else {
-		return node, messages
+	var slots []layoutSlot
+	if falseSharingFlag {
+		// Reported unconditionally, even if no reorder below ends up paying
+		// off: a hot field sharing a cache line with its neighbors is a risk
+		// in the *current* layout, independent of whether reordering helps.
+		messages = append(messages, falseSharingNotes(name, typ, &primary, flat)...)
+		slots = addCacheLinePadding(typ, flat, reportIndexes, &primary)
+	}
+
+	// Check every requested architecture before committing to this reorder:
+	// an order that shrinks the struct on amd64 could easily grow it on 386,
+	// so the fix is only offered when it's a strict win everywhere. Under
+	// -falsesharing, the padded slot layout is what's actually applied, so
+	// its size/ptrdata -- not optimalOrder's unpadded optimal -- is what must
+	// improve; the padding can easily outweigh whatever the reorder saved.
+	var deltas []string
+	for _, t := range targets {
+		sz, ptrs := t.sizes.Sizeof(typ), t.sizes.ptrdata(typ)
+		var optsz, optptrs int64
+		if falseSharingFlag {
+			optsz, optptrs = layoutSizeof(slots, &t.sizes), layoutPtrdata(slots, &t.sizes)
+		} else {
+			optsz, optptrs = t.sizes.Sizeof(reportOptimal), t.sizes.ptrdata(reportOptimal)
+		}
+		if optsz < sz {
+			deltas = append(deltas, archMessage(name, t.name, true, sz, optsz))
+			continue
+		}
+		if optsz == sz && optptrs < ptrs {
+			deltas = append(deltas, archMessage(name, t.name, false, ptrs, optptrs))
+			continue
+		}
+		return node, messages, fixable
+	}
+	messages = append(messages, deltas...)
+
+	if !fixable {
+		// The improvement above is real but belongs to the unconstrained
+		// order, not the one that's safe to apply automatically: offer the
+		// note, not a SuggestedFix.
+		return node, messages, fixable
 	}
 
 	var reordered []*ast.Field
-	for _, index := range indexes {
-		reordered = append(reordered, flat[index])
+	if falseSharingFlag {
+		reordered = layoutFields(slots)
+	} else {
+		for _, index := range indexes {
+			reordered = append(reordered, flat[index])
+		}
+	}
+
+	// The struct-level leading comment and the dangling comment above the
+	// closing brace aren't attached to any single field, so reordering would
+	// otherwise drop them; pin them to whichever field ends up first/last.
+	if lead != nil {
+		reordered[0].Doc = mergeComments(lead, reordered[0].Doc)
+	}
+	if trail != nil {
+		last := reordered[len(reordered)-1]
+		last.Comment = mergeComments(last.Comment, trail)
 	}
 
 	return &ast.StructType{
 		Fields: &ast.FieldList{
 			List: reordered,
 		},
-	}, messages
+	}, messages, fixable
 }
 
-func fieldalignment(name string, pass *analysis.Pass, node *ast.StructType) {
-	newStr, messages := resortFields(name, pass, node)
-	if len(messages) == 0 {
-		return
+// anyPinned reports whether pinned has any true entry.
+func anyPinned(pinned []bool) bool {
+	for _, p := range pinned {
+		if p {
+			return true
+		}
 	}
-	// Write the newly aligned struct node to get the content for suggested fixes.
-	var buf bytes.Buffer
-	if err := format.Node(&buf, token.NewFileSet(), newStr); err != nil {
+	return false
+}
+
+// intsEqual reports whether a and b hold the same values in the same order.
+func intsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if b[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// floatingComments finds comment groups that sit inside typ's braces but
+// aren't already claimed as a field's Doc or Comment: one directly under
+// "struct {" before the first field, and one directly above the closing "}".
+func floatingComments(comments []*ast.CommentGroup, typ *ast.StructType) (lead, trail *ast.CommentGroup) {
+	fields := typ.Fields.List
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	first, last := fields[0], fields[len(fields)-1]
+	firstPos := first.Pos()
+	if first.Doc != nil {
+		firstPos = first.Doc.Pos()
+	}
+	lastEnd := last.End()
+	for _, c := range comments {
+		switch {
+		case isFieldComment(fields, c):
+			continue
+		case c.Pos() > typ.Fields.Opening && c.End() < firstPos:
+			lead = c
+		case c.Pos() > lastEnd && c.End() < typ.Fields.Closing:
+			trail = c
+		default:
+			// c sits between two interior fields without go/parser having
+			// attached it as either one's Doc -- typically because a blank
+			// line separates it from the field that follows. Fold it into
+			// that field's Doc now, before resortFields reorders the field
+			// list, so it travels along by pointer instead of being
+			// silently dropped.
+			attachInteriorComment(fields, c)
+		}
+	}
+	return lead, trail
+}
+
+// isFieldComment reports whether c is already attached as some field's Doc
+// or Comment, so floatingComments doesn't mistake it for an orphan.
+func isFieldComment(fields []*ast.Field, c *ast.CommentGroup) bool {
+	for _, f := range fields {
+		if c == f.Doc || c == f.Comment {
+			return true
+		}
+	}
+	return false
+}
+
+// attachInteriorComment folds c into the Doc of whichever field in fields
+// comes right after it in source order.
+func attachInteriorComment(fields []*ast.Field, c *ast.CommentGroup) {
+	for _, f := range fields {
+		docPos := f.Pos()
+		if f.Doc != nil {
+			docPos = f.Doc.Pos()
+		}
+		if c.End() < docPos {
+			f.Doc = mergeComments(c, f.Doc)
+			return
+		}
+	}
+}
+
+// cloneTypeExpr shallow-copies the common type-expression node kinds so a
+// multi-name field (e.g. `X, Y bool`) can give each split-out field its own
+// Type node instead of sharing one by pointer. Node kinds that don't nest
+// further (or that already come back as fresh nodes from resortFields, such
+// as struct and array types) are returned as-is.
+func cloneTypeExpr(e ast.Expr) ast.Expr {
+	switch t := e.(type) {
+	case *ast.Ident:
+		cp := *t
+		return &cp
+	case *ast.SelectorExpr:
+		cp := *t
+		cp.X = cloneTypeExpr(t.X)
+		return &cp
+	case *ast.StarExpr:
+		cp := *t
+		cp.X = cloneTypeExpr(t.X)
+		return &cp
+	case *ast.MapType:
+		cp := *t
+		cp.Key = cloneTypeExpr(t.Key)
+		cp.Value = cloneTypeExpr(t.Value)
+		return &cp
+	case *ast.ChanType:
+		cp := *t
+		cp.Value = cloneTypeExpr(t.Value)
+		return &cp
+	case *ast.ParenExpr:
+		cp := *t
+		cp.X = cloneTypeExpr(t.X)
+		return &cp
+	case *ast.ArrayType:
+		cp := *t
+		cp.Elt = cloneTypeExpr(t.Elt)
+		return &cp
+	case *ast.Ellipsis:
+		cp := *t
+		cp.Elt = cloneTypeExpr(t.Elt)
+		return &cp
+	default:
+		return e
+	}
+}
+
+// cloneBasicLit shallow-copies a struct tag literal so a multi-name field's
+// split-out fields don't share one Tag node by pointer (see cloneTypeExpr).
+func cloneBasicLit(b *ast.BasicLit) *ast.BasicLit {
+	if b == nil {
+		return nil
+	}
+	cp := *b
+	return &cp
+}
+
+// hotFieldDirective marks a field "hot" for -falsesharing via a leading
+// comment, e.g.:
+//
+//	//malignment:hotfield
+//	Counter int64
+const hotFieldDirective = "malignment:hotfield"
+
+// tagKey is the struct tag namespace this analyzer reads field directives
+// from, e.g. `malignment:"hot"` or `malignment:"fixed"`.
+const tagKey = "malignment"
+
+// hotTagValue is the tagKey value that marks a field "hot" for -falsesharing.
+const hotTagValue = "hot"
+
+// fixedTagValue is the tagKey value that pins a field's position, e.g.
+// `malignment:"fixed"`, because it can't move for ABI or serialization
+// reasons.
+const fixedTagValue = "fixed"
+
+// isHotField reports whether f is marked hot, meaning it's written
+// independently of its neighbors (typically by a different goroutine) and so
+// should get its own cache line rather than share one in the suggested
+// layout.
+func isHotField(f *ast.Field) bool {
+	return hasDirective(f.Doc, hotFieldDirective) || hasTagValue(f.Tag, hotTagValue)
+}
+
+// isFixedField reports whether f is pinned in place via a
+// `malignment:"fixed"` struct tag, e.g. because its offset is part of an ABI
+// or wire format that can't change.
+func isFixedField(f *ast.Field) bool {
+	return hasTagValue(f.Tag, fixedTagValue)
+}
+
+// hasDirective reports whether doc carries directive as a standalone leading
+// comment line, e.g. "//malignment:ignore".
+func hasDirective(doc *ast.CommentGroup, directive string) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+		if text == directive {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTagValue reports whether tag's tagKey entry equals value.
+func hasTagValue(tag *ast.BasicLit, value string) bool {
+	if tag == nil {
+		return false
+	}
+	unquoted, err := strconv.Unquote(tag.Value)
+	if err != nil {
+		return false
+	}
+	return reflect.StructTag(unquoted).Get(tagKey) == value
+}
+
+// fieldName returns f's field name, or a placeholder for an embedded field.
+func fieldName(f *ast.Field) string {
+	if len(f.Names) > 0 {
+		return f.Names[0].Name
+	}
+	return "<anonymous>"
+}
+
+// fieldOffsets returns the byte offset of each field of t under sizes, using
+// the same layout algorithm as Sizeof.
+func fieldOffsets(t *types.Struct, sizes *gcSizes) []int64 {
+	nf := t.NumFields()
+	offsets := make([]int64, nf)
+	var o int64
+	for i := 0; i < nf; i++ {
+		ft := t.Field(i).Type()
+		o = align(o, sizes.Alignof(ft))
+		offsets[i] = o
+		o += sizes.Sizeof(ft)
+	}
+	return offsets
+}
+
+// falseSharingNotes flags every hot field (by flat's index, which lines up
+// with typ.Field's index) whose current byte range shares a CacheLine-sized
+// block with another field: two goroutines updating either one will then
+// contend on the same cache line even though they touch unrelated memory.
+func falseSharingNotes(name string, typ *types.Struct, sizes *gcSizes, flat []*ast.Field) []string {
+	offsets := fieldOffsets(typ, sizes)
+	var notes []string
+	for i, f := range flat {
+		if !isHotField(f) {
+			continue
+		}
+		start := offsets[i]
+		end := start + sizes.Sizeof(typ.Field(i).Type())
+		startLine, endLine := start/sizes.CacheLine, (end-1)/sizes.CacheLine
+		for j := range flat {
+			if j == i {
+				continue
+			}
+			ostart := offsets[j]
+			oend := ostart + sizes.Sizeof(typ.Field(j).Type())
+			oStartLine, oEndLine := ostart/sizes.CacheLine, (oend-1)/sizes.CacheLine
+			if oStartLine <= endLine && oEndLine >= startLine {
+				notes = append(notes, fmt.Sprintf("%s.%s is marked hot but shares a cache line with %s in the current layout", name, fieldName(f), fieldName(flat[j])))
+				break
+			}
+		}
+	}
+	return notes
+}
+
+// paddingField returns a synthetic "_ [n]byte" field used to push a hot
+// field, or its neighbor, onto its own cache line.
+func paddingField(n int64) *ast.Field {
+	return &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent("_")},
+		Type: &ast.ArrayType{
+			Len: &ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("%d", n)},
+			Elt: ast.NewIdent("byte"),
+		},
+	}
+}
+
+// layoutSlot is one slot in a -falsesharing layout: either a real field
+// (ft is its type, for size/ptrdata math) or synthetic cache-line padding
+// (field is nil, pad is its byte count).
+type layoutSlot struct {
+	field *ast.Field
+	ft    types.Type
+	pad   int64
+}
+
+// addCacheLinePadding lays out typ's fields in the order optimalOrder chose,
+// inserting synthetic padding slots before and after each hot field so it
+// starts, and ends, on its own CacheLine-sized boundary. Sizing (and so how
+// much padding is needed) is computed under sizes, the primary -arch target;
+// the resulting slot sequence is fixed from there, but its real size under
+// every target is recomputed from it directly via layoutSizeof/layoutPtrdata
+// rather than from optimalOrder's (unpadded) struct, since the padding can
+// change which layout is actually smaller.
+func addCacheLinePadding(typ *types.Struct, flat []*ast.Field, indexes []int, sizes *gcSizes) []layoutSlot {
+	var slots []layoutSlot
+	var o int64
+	for _, index := range indexes {
+		f := flat[index]
+		ft := typ.Field(index).Type()
+		o = align(o, sizes.Alignof(ft))
+		hot := isHotField(f)
+		if hot {
+			if pad := align(o, sizes.CacheLine) - o; pad > 0 {
+				slots = append(slots, layoutSlot{pad: pad})
+				o += pad
+			}
+		}
+		slots = append(slots, layoutSlot{field: f, ft: ft})
+		o += sizes.Sizeof(ft)
+		if hot {
+			if pad := align(o, sizes.CacheLine) - o; pad > 0 {
+				slots = append(slots, layoutSlot{pad: pad})
+				o += pad
+			}
+		}
+	}
+	return slots
+}
+
+// layoutFields materializes slots into an ast.Field list, building a
+// synthetic "_ [N]byte" field for each padding slot.
+func layoutFields(slots []layoutSlot) []*ast.Field {
+	fields := make([]*ast.Field, len(slots))
+	for i, s := range slots {
+		if s.field != nil {
+			fields[i] = s.field
+		} else {
+			fields[i] = paddingField(s.pad)
+		}
+	}
+	return fields
+}
+
+// layoutSizeof and layoutPtrdata compute a slot sequence's size and pointer
+// bytes under sizes, using the same cumulative offset/alignment math as
+// gcSizes.Sizeof/ptrdata's *types.Struct case, so padding inserted by
+// addCacheLinePadding is accounted for under any -arch target.
+func layoutSizeof(slots []layoutSlot, sizes *gcSizes) int64 {
+	if len(slots) == 0 {
+		return 0
+	}
+	var o int64
+	max := int64(1)
+	for i, s := range slots {
+		a, sz := slotAlignof(s, sizes), slotSizeof(s, sizes)
+		if a > max {
+			max = a
+		}
+		if i == len(slots)-1 && sz == 0 && o != 0 {
+			sz = 1
+		}
+		o = align(o, a) + sz
+	}
+	return align(o, max)
+}
+
+func layoutPtrdata(slots []layoutSlot, sizes *gcSizes) int64 {
+	var o, p int64
+	for _, s := range slots {
+		a, sz, fp := slotAlignof(s, sizes), slotSizeof(s, sizes), slotPtrdata(s, sizes)
+		o = align(o, a)
+		if fp != 0 {
+			p = o + fp
+		}
+		o += sz
+	}
+	return p
+}
+
+func slotAlignof(s layoutSlot, sizes *gcSizes) int64 {
+	if s.field == nil {
+		return 1 // a "_ [N]byte" padding field is always byte-aligned.
+	}
+	return sizes.Alignof(s.ft)
+}
+
+func slotSizeof(s layoutSlot, sizes *gcSizes) int64 {
+	if s.field == nil {
+		return s.pad
+	}
+	return sizes.Sizeof(s.ft)
+}
+
+func slotPtrdata(s layoutSlot, sizes *gcSizes) int64 {
+	if s.field == nil {
+		return 0
+	}
+	return sizes.ptrdata(s.ft)
+}
+
+// mergeComments concatenates non-nil comment groups, in order, into one.
+func mergeComments(groups ...*ast.CommentGroup) *ast.CommentGroup {
+	var list []*ast.Comment
+	for _, g := range groups {
+		if g != nil {
+			list = append(list, g.List...)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return &ast.CommentGroup{List: list}
+}
+
+// archMessage reports the size (isSize) or pointer-bytes savings a struct
+// would get from reordering, prefixed with the GOARCH it was measured under
+// when arch is non-empty (multi-architecture mode).
+func archMessage(name, arch string, isSize bool, before, after int64) string {
+	label := name
+	if arch != "" {
+		label = fmt.Sprintf("%s[%s]", name, arch)
+	}
+	pct := float64(before-after) * 100 / float64(before)
+	if isSize {
+		return fmt.Sprintf("%s struct of size %d could be %d  save %.2f%%", label, before, after, pct)
+	}
+	return fmt.Sprintf("%s struct with %d pointer bytes could be %d save %.2f%%", label, before, after, pct)
+}
+
+func fieldalignment(name string, pass *analysis.Pass, comments []*ast.CommentGroup, targets []archTarget, node *ast.StructType) {
+	newStr, messages, fixable := resortFields(name, pass, comments, targets, node)
+	if len(messages) == 0 {
 		return
 	}
-	pass.Report(analysis.Diagnostic{
+	diag := analysis.Diagnostic{
 		Pos:     node.Pos(),
 		End:     node.Pos() + token.Pos(len("struct")),
 		Message: strings.Join(messages, ","),
-		SuggestedFixes: []analysis.SuggestedFix{{
-			Message: "Rearrange fields",
-			TextEdits: []analysis.TextEdit{{
-				Pos:     node.Pos(),
-				End:     node.End(),
-				NewText: buf.Bytes(),
-			}},
+	}
+	if !fixable || newStr == ast.Expr(node) {
+		// !fixable: a `malignment:"fixed"` field pinned part of this struct
+		// away from its true optimal order; still worth flagging, but
+		// applying the suggestion automatically could reorder a field the
+		// user deliberately kept in place.
+		//
+		// newStr == node: resortFields found nothing worth reordering (e.g.
+		// -falsesharing's padding would cost more than the reorder saves),
+		// so node is still the original, pass.Fset-positioned struct. Running
+		// it through renumberPositions would overwrite node's own positions
+		// with synthetic ones from a throwaway FileSet, corrupting the Pos
+		// and End a SuggestedFix below would need.
+		//
+		// Either way, report the finding with no SuggestedFix.
+		pass.Report(diag)
+		return
+	}
+	// Reordering leaves each field's Doc/Comment holding its original source
+	// position, which no longer matches the fields' new order and confuses
+	// go/printer's comment placement. Renumber every node in a fresh FileSet
+	// so positions increase left-to-right in the new, printed order.
+	fset := token.NewFileSet()
+	renumberPositions(fset, newStr)
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, newStr); err != nil {
+		pass.Report(diag)
+		return
+	}
+	diag.SuggestedFixes = []analysis.SuggestedFix{{
+		Message: "Rearrange fields",
+		TextEdits: []analysis.TextEdit{{
+			Pos:     node.Pos(),
+			End:     node.End(),
+			NewText: buf.Bytes(),
 		}},
-	})
+	}}
+	pass.Report(diag)
+}
+
+// renumberPositions assigns every position-bearing node under root a fresh
+// token.Pos within fset, laid out the way the nodes are meant to print: each
+// field on its own synthetic line, a field's Doc comments each on their own
+// line above it, and everything else on the field's line. Reordering fields
+// leaves their Doc/Comment groups holding source positions that reflect the
+// old order, which confuses go/printer's leading-vs-trailing comment
+// placement (it decides using line numbers); this rebuilds positions that
+// match the new order instead.
+func renumberPositions(fset *token.FileSet, root ast.Node) {
+	st, ok := root.(*ast.StructType)
+	if !ok {
+		return
+	}
+	file := fset.AddFile("", fset.Base(), countPositions(st)+1)
+	renumberStruct(&posAlloc{file: file}, st)
+}
+
+// posAlloc hands out strictly increasing positions within file, optionally
+// starting a new synthetic source line first.
+type posAlloc struct {
+	file   *token.File
+	offset int
+}
+
+func (a *posAlloc) newLine() {
+	if a.offset > 0 {
+		a.file.AddLine(a.offset)
+	}
+}
+
+func (a *posAlloc) next() token.Pos {
+	pos := token.Pos(a.file.Base() + a.offset)
+	a.offset++
+	return pos
+}
+
+func renumberStruct(a *posAlloc, st *ast.StructType) {
+	st.Struct = a.next()
+	st.Fields.Opening = a.next()
+	for _, f := range st.Fields.List {
+		a.newLine()
+		if f.Doc != nil {
+			for _, c := range f.Doc.List {
+				c.Slash = a.next()
+				a.newLine()
+			}
+		}
+		for _, id := range f.Names {
+			id.NamePos = a.next()
+		}
+		renumberTypeExpr(a, f.Type)
+		if f.Tag != nil {
+			f.Tag.ValuePos = a.next()
+		}
+		if f.Comment != nil {
+			for _, c := range f.Comment.List {
+				c.Slash = a.next()
+			}
+		}
+	}
+	a.newLine()
+	st.Fields.Closing = a.next()
+}
+
+// renumberTypeExpr assigns positions to a field's type expression, all on
+// the current line, except a nested struct type which lays out its own
+// fields on their own lines via renumberStruct.
+func renumberTypeExpr(a *posAlloc, e ast.Expr) {
+	switch t := e.(type) {
+	case *ast.Ident:
+		t.NamePos = a.next()
+	case *ast.StarExpr:
+		t.Star = a.next()
+		renumberTypeExpr(a, t.X)
+	case *ast.SelectorExpr:
+		renumberTypeExpr(a, t.X)
+		t.Sel.NamePos = a.next()
+	case *ast.ArrayType:
+		t.Lbrack = a.next()
+		if t.Len != nil {
+			renumberTypeExpr(a, t.Len)
+		}
+		renumberTypeExpr(a, t.Elt)
+	case *ast.Ellipsis:
+		t.Ellipsis = a.next()
+		renumberTypeExpr(a, t.Elt)
+	case *ast.MapType:
+		t.Map = a.next()
+		renumberTypeExpr(a, t.Key)
+		renumberTypeExpr(a, t.Value)
+	case *ast.ChanType:
+		t.Begin = a.next()
+		renumberTypeExpr(a, t.Value)
+	case *ast.ParenExpr:
+		renumberTypeExpr(a, t.X)
+	case *ast.StructType:
+		a.newLine()
+		renumberStruct(a, t)
+	}
+}
+
+// countPositions returns an upper bound on how many synthetic positions
+// renumberStruct will hand out for st, used to size the file it writes into.
+func countPositions(st *ast.StructType) int {
+	n := 3 // st.Struct, st.Fields.Opening, st.Fields.Closing
+	for _, f := range st.Fields.List {
+		if f.Doc != nil {
+			n += len(f.Doc.List)
+		}
+		n += len(f.Names)
+		if nested, ok := f.Type.(*ast.StructType); ok {
+			n += countPositions(nested)
+		} else {
+			n += 8 // generous bound for a non-struct type expression
+		}
+		if f.Tag != nil {
+			n++
+		}
+		if f.Comment != nil {
+			n += len(f.Comment.List)
+		}
+	}
+	return n
 }
 
-func optimalOrder(str *types.Struct, sizes *gcSizes) (*types.Struct, []int) {
+// optimalOrder returns str's fields reordered to minimize size (then pointer
+// bytes). pinned, if non-nil, marks fields by index that must stay at their
+// original position, e.g. because of a `malignment:"fixed"` tag; only the
+// unpinned fields are sorted, and they're interleaved back into the
+// remaining slots in that sorted order.
+func optimalOrder(str *types.Struct, sizes *gcSizes, pinned []bool) (*types.Struct, []int) {
 	nf := str.NumFields()
 
 	type elem struct {
@@ -179,21 +924,24 @@ func optimalOrder(str *types.Struct, sizes *gcSizes) (*types.Struct, []int) {
 		ptrdata int64
 	}
 
-	elems := make([]elem, nf)
+	var free []elem
 	for i := 0; i < nf; i++ {
+		if i < len(pinned) && pinned[i] {
+			continue
+		}
 		field := str.Field(i)
 		ft := field.Type()
-		elems[i] = elem{
+		free = append(free, elem{
 			i,
 			sizes.Alignof(ft),
 			sizes.Sizeof(ft),
 			sizes.ptrdata(ft),
-		}
+		})
 	}
 
-	sort.Slice(elems, func(i, j int) bool {
-		ei := &elems[i]
-		ej := &elems[j]
+	sort.Slice(free, func(i, j int) bool {
+		ei := &free[i]
+		ej := &free[j]
 
 		// Place zero sized objects before non-zero sized objects.
 		zeroi := ei.sizeof == 0
@@ -202,7 +950,13 @@ func optimalOrder(str *types.Struct, sizes *gcSizes) (*types.Struct, []int) {
 			return zeroi
 		}
 
-		// Next, place more tightly aligned objects before less tightly aligned objects.
+		// Next, place more tightly aligned objects before less tightly aligned
+		// objects. align(), below in Sizeof/ptrdata, always pads a field's
+		// offset up to its own Alignof regardless of what precedes it, so a
+		// field requiring more alignment than MaxAlign (e.g. one embedding
+		// sync/atomic.align64 on a 32-bit target) is never placed somewhere
+		// that would violate its alignment; sorting by alignof first just
+		// minimizes how much padding that costs.
 		if ei.alignof != ej.alignof {
 			return ei.alignof > ej.alignof
 		}
@@ -237,23 +991,45 @@ func optimalOrder(str *types.Struct, sizes *gcSizes) (*types.Struct, []int) {
 		return false
 	})
 
-	fields := make([]*types.Var, nf)
 	indexes := make([]int, nf)
-	for i, e := range elems {
-		fields[i] = str.Field(e.index)
-		indexes[i] = e.index
+	fi := 0
+	for i := 0; i < nf; i++ {
+		if i < len(pinned) && pinned[i] {
+			indexes[i] = i
+			continue
+		}
+		indexes[i] = free[fi].index
+		fi++
+	}
+
+	fields := make([]*types.Var, nf)
+	for i, index := range indexes {
+		fields[i] = str.Field(index)
 	}
 	return types.NewStruct(fields, nil), indexes
 }
 
 // Code below based on go/types.StdSizes.
 
+// defaultCacheLine is the assumed CPU cache line size in bytes, used to keep
+// -falsesharing's hot-field padding from sharing a line with its neighbors.
+const defaultCacheLine = 64
+
 type gcSizes struct {
-	WordSize int64
-	MaxAlign int64
+	WordSize  int64
+	MaxAlign  int64
+	CacheLine int64
 }
 
 func (s *gcSizes) Alignof(T types.Type) int64 {
+	// sync/atomic.align64 is a zero-size marker the compiler special-cases to
+	// force 8-byte alignment, keeping atomic.Int64/Uint64 correctly aligned
+	// for 64-bit atomic ops even on 32-bit platforms where MaxAlign is 4.
+	// Treating it like an ordinary empty struct (alignment 1) would let the
+	// suggested reorder silently break that guarantee.
+	if isAtomicAlign64(T) {
+		return 8
+	}
 	// For arrays and structs, alignment is defined in terms
 	// of alignment of the elements and fields, respectively.
 	switch t := T.Underlying().(type) {
@@ -284,6 +1060,18 @@ func (s *gcSizes) Alignof(T types.Type) int64 {
 	return a
 }
 
+// isAtomicAlign64 reports whether T is sync/atomic's unexported align64
+// marker type, a zero-field struct embedded in atomic.Int64, atomic.Uint64,
+// and similar types purely to force 8-byte alignment.
+func isAtomicAlign64(T types.Type) bool {
+	named, ok := T.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "sync/atomic" && obj.Name() == "align64"
+}
+
 var basicSizes = [...]byte{
 	types.Bool:       1,
 	types.Int8:       1,
@@ -0,0 +1,163 @@
+package malignment
+
+import (
+	"bytes"
+	"go/ast"
+	"go/types"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// runAnalyzer type-checks src as package p and runs the analyzer's own run
+// func over it -- the same way singlechecker would -- with -arch set to arch
+// (empty for the default single-target behavior); returns whatever
+// diagnostics got reported.
+func runAnalyzer(t *testing.T, src, arch string) []analysis.Diagnostic {
+	t.Helper()
+	archFlag = arch
+	defer func() { archFlag = "" }()
+
+	fset, f, info, _ := typecheckPkg(t, src)
+	var diags []analysis.Diagnostic
+	pass := &analysis.Pass{
+		Fset:       fset,
+		Files:      []*ast.File{f},
+		TypesInfo:  info,
+		TypesSizes: types.SizesFor("gc", "amd64"),
+		Report:     func(d analysis.Diagnostic) { diags = append(diags, d) },
+	}
+	if _, err := run(pass); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return diags
+}
+
+// applyFix applies d's first SuggestedFix's first TextEdit to src, the way
+// -fix would, and returns the patched source text.
+func applyFix(t *testing.T, src string, d analysis.Diagnostic) string {
+	t.Helper()
+	if len(d.SuggestedFixes) == 0 || len(d.SuggestedFixes[0].TextEdits) == 0 {
+		t.Fatalf("diagnostic %q has no SuggestedFix to apply", d.Message)
+	}
+	fset, _, _, _ := typecheckPkg(t, src)
+	edit := d.SuggestedFixes[0].TextEdits[0]
+	base, end := fset.Position(edit.Pos).Offset, fset.Position(edit.End).Offset
+	var out bytes.Buffer
+	out.WriteString(src[:base])
+	out.Write(edit.NewText)
+	out.WriteString(src[end:])
+	return out.String()
+}
+
+// diagnosticWithFix returns the first diagnostic among diags carrying a
+// SuggestedFix, or fails the test if none do.
+func diagnosticWithFix(t *testing.T, diags []analysis.Diagnostic) analysis.Diagnostic {
+	t.Helper()
+	for _, d := range diags {
+		if len(d.SuggestedFixes) > 0 {
+			return d
+		}
+	}
+	t.Fatalf("no diagnostic with a suggested fix among %d diagnostics", len(diags))
+	return analysis.Diagnostic{}
+}
+
+// TestSuggestedFixPreservesFieldComment covers chunk0-1: a field's doc
+// comment must travel with it when resortFields reorders the field list,
+// not get dropped from the suggested fix.
+func TestSuggestedFixPreservesFieldComment(t *testing.T) {
+	const src = `package p
+
+type T struct {
+	A bool
+	// keep me
+	B int64
+	C bool
+	D int64
+}
+`
+	diags := runAnalyzer(t, src, "")
+	fixed := applyFix(t, src, diagnosticWithFix(t, diags))
+
+	lines := strings.Split(fixed, "\n")
+	var commentLine = -1
+	for i, l := range lines {
+		if strings.Contains(l, "keep me") {
+			commentLine = i
+		}
+	}
+	if commentLine == -1 {
+		t.Fatalf("suggested fix dropped B's doc comment:\n%s", fixed)
+	}
+	if !strings.Contains(lines[commentLine+1], "B int64") {
+		t.Fatalf("\"keep me\" no longer precedes B after the fix:\n%s", fixed)
+	}
+
+	if _, _, _, pkg := typecheckPkg(t, fixed); pkg.Scope().Lookup("T") == nil {
+		t.Fatalf("suggested fix produced invalid Go:\n%s", fixed)
+	}
+}
+
+// TestArchFilteringRequiresImprovementOnEveryTarget covers chunk0-2: a
+// reorder that shrinks S on amd64 but, once 386's 4-byte MaxAlign caps
+// int64's alignment down to int32's, makes no difference there at all must
+// only be offered when -arch doesn't also ask for 386.
+func TestArchFilteringRequiresImprovementOnEveryTarget(t *testing.T) {
+	const src = `package p
+
+type S struct {
+	A bool
+	B int64
+	C int32
+}
+`
+	if diags := runAnalyzer(t, src, "amd64"); len(diags) == 0 {
+		t.Fatalf("-arch=amd64: want a diagnostic, got none")
+	}
+	if diags := runAnalyzer(t, src, "386"); len(diags) != 0 {
+		t.Fatalf("-arch=386: want no diagnostic (no improvement there), got %v", diags)
+	}
+	if diags := runAnalyzer(t, src, "amd64,386"); len(diags) != 0 {
+		t.Fatalf("-arch=amd64,386: want no diagnostic (386 gates the fix), got %v", diags)
+	}
+}
+
+// TestIgnoreDirectiveSkipsStruct covers half of chunk0-5: a struct opted out
+// with a leading "//malignment:ignore" comment must never be reported at
+// all, even though it would otherwise get a suggested fix.
+func TestIgnoreDirectiveSkipsStruct(t *testing.T) {
+	const src = `package p
+
+//malignment:ignore
+type T struct {
+	A bool
+	B int64
+	C bool
+	D int64
+}
+`
+	if diags := runAnalyzer(t, src, ""); len(diags) != 0 {
+		t.Fatalf("want no diagnostics for an ignored struct, got %v", diags)
+	}
+}
+
+// TestFixedFieldReportsWithoutSuggestedFix covers the other half of
+// chunk0-5: a `malignment:"fixed"` field must still get reported as an
+// informational note when it pins the layout away from the true optimal
+// order, but never with a SuggestedFix that would move it.
+func TestFixedFieldReportsWithoutSuggestedFix(t *testing.T) {
+	const src = "package p\n\ntype T struct {\n\tA bool\n\tB int64 `malignment:\"fixed\"`\n\tC bool\n}\n"
+
+	diags := runAnalyzer(t, src, "")
+	if len(diags) != 1 {
+		t.Fatalf("got %d diagnostics, want 1: %v", len(diags), diags)
+	}
+	if len(diags[0].SuggestedFixes) != 0 {
+		t.Fatalf("got a SuggestedFix for a struct with a fixed field: %q", diags[0].Message)
+	}
+	if !strings.Contains(diags[0].Message, "could be") {
+		t.Fatalf("message = %q, want the informational savings note", diags[0].Message)
+	}
+}
@@ -0,0 +1,166 @@
+package malignment
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+const hotFieldSrc = `package p
+
+type S struct {
+	A bool
+	//malignment:hotfield
+	B int64
+	C bool
+	D int64
+}
+`
+
+// hotFieldWinSrc is shaped so isolating the hot field onto its own cache
+// line is free (it already lands on a 64-byte boundary and is itself
+// exactly 64 bytes), so the reorder is a genuine win rather than one the
+// padding outweighs.
+const hotFieldWinSrc = `package p
+
+type S struct {
+	A bool
+	H1 int64
+	H2 int64
+	H3 int64
+	H4 int64
+	H5 int64
+	H6 int64
+	H7 int64
+	H8 int64
+	//malignment:hotfield
+	Buf [64]byte
+	C bool
+}
+`
+
+// amd64Sizes mirrors the gcSizes archTargets builds for amd64 when -arch
+// isn't given, so tests can independently measure a struct's size the same
+// way the analyzer itself does.
+func amd64Sizes() *gcSizes {
+	return &gcSizes{WordSize: 8, MaxAlign: 8, CacheLine: defaultCacheLine}
+}
+
+// typecheckPkg parses and type-checks src as a standalone package, returning
+// enough to both run the analyzer over it and measure real struct sizes
+// afterwards.
+func typecheckPkg(t *testing.T, src string) (*token.FileSet, *ast.File, *types.Info, *types.Package) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{Importer: importer.Default()}
+	pkg, err := conf.Check("p", fset, []*ast.File{f}, info)
+	if err != nil {
+		t.Fatalf("typecheck: %v", err)
+	}
+	return fset, f, info, pkg
+}
+
+// runFalseSharing runs the analyzer with -falsesharing over src under amd64
+// sizes and returns whatever diagnostics it reported.
+func runFalseSharing(t *testing.T, src string) []analysis.Diagnostic {
+	t.Helper()
+	falseSharingFlag = true
+	defer func() { falseSharingFlag = false }()
+
+	fset, f, info, _ := typecheckPkg(t, src)
+	var diags []analysis.Diagnostic
+	pass := &analysis.Pass{
+		Fset:       fset,
+		Files:      []*ast.File{f},
+		TypesInfo:  info,
+		TypesSizes: types.SizesFor("gc", "amd64"),
+		Report:     func(d analysis.Diagnostic) { diags = append(diags, d) },
+	}
+	if _, err := run(pass); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return diags
+}
+
+// TestFalseSharingSuggestedFixMatchesReportedSize applies -falsesharing's own
+// suggested fix to the source text, re-typechecks the result, and confirms
+// the struct's real unsafe.Sizeof matches what the diagnostic claimed --
+// exercising addCacheLinePadding end-to-end instead of trusting optimalOrder
+// and the applied fix to agree in isolation.
+func TestFalseSharingSuggestedFixMatchesReportedSize(t *testing.T) {
+	diags := runFalseSharing(t, hotFieldWinSrc)
+
+	var withFix *analysis.Diagnostic
+	for i := range diags {
+		if len(diags[i].SuggestedFixes) > 0 {
+			withFix = &diags[i]
+		}
+	}
+	if withFix == nil {
+		t.Fatalf("no diagnostic with a suggested fix among %d diagnostics", len(diags))
+	}
+
+	m := regexp.MustCompile(`struct of size (\d+) could be (\d+)`).FindStringSubmatch(withFix.Message)
+	if m == nil {
+		t.Fatalf("message %q doesn't report a size improvement", withFix.Message)
+	}
+	wantSize, _ := strconv.Atoi(m[2])
+
+	fset, _, _, _ := typecheckPkg(t, hotFieldWinSrc)
+	edit := withFix.SuggestedFixes[0].TextEdits[0]
+	base, end := fset.Position(edit.Pos).Offset, fset.Position(edit.End).Offset
+
+	var fixed bytes.Buffer
+	fixed.WriteString(hotFieldWinSrc[:base])
+	fixed.Write(edit.NewText)
+	fixed.WriteString(hotFieldWinSrc[end:])
+
+	_, _, _, fixedPkg := typecheckPkg(t, fixed.String())
+	obj := fixedPkg.Scope().Lookup("S")
+	if obj == nil {
+		t.Fatalf("fixed source no longer declares S:\n%s", fixed.String())
+	}
+	st, ok := obj.Type().Underlying().(*types.Struct)
+	if !ok {
+		t.Fatalf("S is no longer a struct after the fix")
+	}
+	gotSize := amd64Sizes().Sizeof(st)
+	if int64(wantSize) != gotSize {
+		t.Fatalf("message claims size %d, but the suggested fix's actual size is %d:\n%s", wantSize, gotSize, fixed.String())
+	}
+}
+
+// TestFalseSharingNoFixWhenPaddingCostsMore is the maintainer's original
+// repro: isolating B onto its own cache line costs far more padding than the
+// reorder could ever save, so -falsesharing must decline to offer a
+// SuggestedFix at all rather than claim a savings that -fix would turn into
+// a 150% size increase.
+func TestFalseSharingNoFixWhenPaddingCostsMore(t *testing.T) {
+	diags := runFalseSharing(t, hotFieldSrc)
+	if len(diags) != 1 {
+		t.Fatalf("got %d diagnostics, want 1: %v", len(diags), diags)
+	}
+	if len(diags[0].SuggestedFixes) != 0 {
+		t.Fatalf("got a SuggestedFix for a reorder that only grows the struct: %q", diags[0].Message)
+	}
+	if !strings.Contains(diags[0].Message, "shares a cache line") {
+		t.Fatalf("message = %q, want a false-sharing note with no size claim", diags[0].Message)
+	}
+}
@@ -0,0 +1,78 @@
+package malignment
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+// newAlign64 builds a synthetic sync/atomic.align64: an unexported, empty
+// struct type named "align64" in package "sync/atomic", matching the shape
+// isAtomicAlign64 looks for without depending on the real standard library
+// type (which is unexported and so isn't reachable via go/importer).
+func newAlign64() *types.Named {
+	pkg := types.NewPackage("sync/atomic", "atomic")
+	name := types.NewTypeName(token.NoPos, pkg, "align64", nil)
+	return types.NewNamed(name, types.NewStruct(nil, nil), nil)
+}
+
+// newAtomicInt64 builds a synthetic atomic.Int64-shaped struct: a
+// zero-field align64 marker alongside the real 8-byte value, mirroring
+// sync/atomic's own layout.
+func newAtomicInt64(align64 *types.Named) *types.Struct {
+	return types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, nil, "_", align64, false),
+		types.NewField(token.NoPos, nil, "v", types.Typ[types.Uint64], false),
+	}, nil)
+}
+
+func TestAtomicAlign64AlwaysAligns8(t *testing.T) {
+	align64 := newAlign64()
+	atomicInt64 := newAtomicInt64(align64)
+
+	tests := []struct {
+		name  string
+		sizes gcSizes
+	}{
+		{"386", gcSizes{WordSize: 4, MaxAlign: 4, CacheLine: defaultCacheLine}},
+		{"amd64", gcSizes{WordSize: 8, MaxAlign: 8, CacheLine: defaultCacheLine}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sizes.Alignof(align64); got != 8 {
+				t.Errorf("Alignof(align64) = %d, want 8", got)
+			}
+			if got := tt.sizes.Alignof(atomicInt64); got != 8 {
+				t.Errorf("Alignof(atomic.Int64-shaped struct) = %d, want 8", got)
+			}
+		})
+	}
+}
+
+// TestOptimalOrderPreservesAtomicAlignment builds a user struct that embeds
+// an atomic.Int64-shaped field among smaller ones and checks that on a
+// 32-bit target (MaxAlign 4), the field requiring 8-byte alignment still
+// lands on an 8-byte boundary after optimalOrder rearranges it.
+func TestOptimalOrderPreservesAtomicAlignment(t *testing.T) {
+	align64 := newAlign64()
+	atomicInt64 := newAtomicInt64(align64)
+
+	user := types.NewStruct([]*types.Var{
+		types.NewField(token.NoPos, nil, "Flag", types.Typ[types.Bool], false),
+		types.NewField(token.NoPos, nil, "Counter", atomicInt64, false),
+		types.NewField(token.NoPos, nil, "Code", types.Typ[types.Int8], false),
+	}, nil)
+
+	sizes := &gcSizes{WordSize: 4, MaxAlign: 4, CacheLine: defaultCacheLine}
+	optimal, _ := optimalOrder(user, sizes, nil)
+
+	var offset int64
+	for i := 0; i < optimal.NumFields(); i++ {
+		ft := optimal.Field(i).Type()
+		offset = align(offset, sizes.Alignof(ft))
+		if ft == types.Type(atomicInt64) && offset%8 != 0 {
+			t.Errorf("atomic.Int64-shaped field landed at offset %d, not 8-byte aligned", offset)
+		}
+		offset += sizes.Sizeof(ft)
+	}
+}